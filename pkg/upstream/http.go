@@ -2,15 +2,16 @@ package upstream
 
 import (
 	"crypto/tls"
-        "net"
+	"crypto/x509"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"strings"
-        "time"
-	"github.com/mbland/hmacauth"
+
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
 const (
@@ -20,6 +21,7 @@ const (
 
 	httpScheme  = "http"
 	httpsScheme = "https"
+	unixScheme  = "unix"
 )
 
 // SignatureHeaders contains the headers to be signed by the hmac algorithm
@@ -41,37 +43,54 @@ var SignatureHeaders = []string{
 // newHTTPUpstreamProxy creates a new httpUpstreamProxy that can serve requests
 // to a single upstream host.
 func newHTTPUpstreamProxy(upstream options.Upstream, u *url.URL, sigData *options.SignatureData, errorHandler ProxyErrorHandler) http.Handler {
-	// Set path to empty so that request paths start at the server root
-	u.Path = ""
+	// Set path to empty so that request paths start at the server root.
+	// A unix:// URL's path is the socket file location, not an HTTP path,
+	// so leave it alone and let newReverseProxy translate it.
+	if u.Scheme != unixScheme {
+		u.Path = ""
+	}
 
-	// Create a ReverseProxy
-	proxy := newReverseProxy(u, upstream, errorHandler)
+	// Create a ReverseProxy, rewriting the path first if the upstream
+	// configures rewrite rules.
+	var proxy http.Handler
+	if len(upstream.RewriteRules) > 0 {
+		rewriteProxy, err := newRewriteReverseProxy(u, upstream, errorHandler)
+		if err != nil {
+			logger.Errorf("error configuring rewrite rules for upstream %q, falling back to unrewritten proxying: %v", upstream.ID, err)
+			rewriteProxy = newReverseProxy(u, upstream, errorHandler)
+		}
+		proxy = rewriteProxy
+	} else {
+		proxy = newReverseProxy(u, upstream, errorHandler)
+	}
 
 	// Set up a WebSocket proxy if required
 	var wsProxy http.Handler
 	if upstream.ProxyWebSockets == nil || *upstream.ProxyWebSockets {
-		wsProxy = newWebSocketReverseProxy(u, upstream.InsecureSkipTLSVerify)
+		wsProxy = newWebSocketReverseProxy(u, upstream)
 	}
 
-	var auth hmacauth.HmacAuth
-	if sigData != nil {
-		auth = hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), SignatureHeader, SignatureHeaders)
+	signer, err := newUpstreamRequestSigner(sigData)
+	if err != nil {
+		logger.Errorf("error configuring request signer for upstream %q: %v", upstream.ID, err)
 	}
 
 	return &httpUpstreamProxy{
-		upstream:  upstream.ID,
-		handler:   proxy,
-		wsHandler: wsProxy,
-		auth:      auth,
+		upstream:      upstream.ID,
+		handler:       proxy,
+		wsHandler:     wsProxy,
+		signer:        signer,
+		proxyProtocol: upstream.ProxyProtocol,
 	}
 }
 
 // httpUpstreamProxy represents a single HTTP(S) upstream proxy
 type httpUpstreamProxy struct {
-	upstream  string
-	handler   http.Handler
-	wsHandler http.Handler
-	auth      hmacauth.HmacAuth
+	upstream      string
+	handler       http.Handler
+	wsHandler     http.Handler
+	signer        UpstreamRequestSigner
+	proxyProtocol string
 }
 
 // ServeHTTP proxies requests to the upstream provider while signing the
@@ -83,9 +102,17 @@ func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	scope.Upstream = h.upstream
 
 	// TODO (@NickMeves) - Deprecate GAP-Signature & remove GAP-Auth
-	if h.auth != nil {
+	if h.signer != nil {
 		req.Header.Set("GAP-Auth", rw.Header().Get("GAP-Auth"))
-		h.auth.SignRequest(req)
+		if err := h.signer.SignRequest(req); err != nil {
+			logger.Errorf("error signing request to upstream %q: %v", h.upstream, err)
+		}
+	}
+	if h.proxyProtocol != "" {
+		// DialContext has no access to the inbound request, so stash the
+		// client address on the context for the dialer wrapped in
+		// buildUpstreamTransport to pick up.
+		req = withProxyProtocolSourceAddr(req)
 	}
 	if h.wsHandler != nil && strings.EqualFold(req.Header.Get("Connection"), "upgrade") && req.Header.Get("Upgrade") == "websocket" {
 		h.wsHandler.ServeHTTP(rw, req)
@@ -99,6 +126,14 @@ func (h *httpUpstreamProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 // The proxy should render an error page if there are failures connecting to the
 // upstream server.
 func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler ProxyErrorHandler) http.Handler {
+	// A unix:// target has no meaningful host to route on, so dial the
+	// socket directly and proxy as if the upstream were on localhost.
+	socketPath := ""
+	if target.Scheme == unixScheme {
+		socketPath = target.Path
+		target = &url.URL{Scheme: httpScheme, Host: "localhost"}
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
 	// Configure options on the SingleHostReverseProxy
@@ -108,34 +143,23 @@ func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler Pr
 		proxy.FlushInterval = options.DefaultUpstreamFlushInterval
 	}
 
-        proxy.Transport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   0 * time.Second,
-			KeepAlive: 0 * time.Second,
-		}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-        }
-
-	// InsecureSkipVerify is a configurable option we allow
-	/* #nosec G402 */
-	if upstream.InsecureSkipTLSVerify {
-		proxy.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-	}
+	proxy.Transport = buildUpstreamTransport(upstream, socketPath)
 
 	// Ensure we always pass the original request path
 	setProxyDirector(proxy)
 
-	if upstream.PassHostHeader != nil && !*upstream.PassHostHeader {
+	// PreserveHost always wins over PassHostHeader: it's the explicit,
+	// newer opt-in for keeping the inbound Host, whereas PassHostHeader
+	// defaults to true for backwards compatibility.
+	preserveHost := upstream.PreserveHost != nil && *upstream.PreserveHost
+	if !preserveHost && upstream.PassHostHeader != nil && !*upstream.PassHostHeader {
 		setProxyUpstreamHostHeader(proxy, target)
 	}
 
+	if upstream.AppendXForwardedHost != nil && *upstream.AppendXForwardedHost {
+		setProxyAppendXForwardedHost(proxy)
+	}
+
 	// Set the error handler so that upstream connection failures render the
 	// error page instead of sending a empty response
 	if errorHandler != nil {
@@ -144,6 +168,42 @@ func newReverseProxy(target *url.URL, upstream options.Upstream, errorHandler Pr
 	return proxy
 }
 
+// buildUpstreamTLSConfig builds the tls.Config used to verify the upstream's
+// certificate and, if configured, to present a client certificate for mTLS.
+func buildUpstreamTLSConfig(upstream options.Upstream) *tls.Config {
+	/* #nosec G402 */
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: upstream.InsecureSkipTLSVerify,
+		ServerName:         upstream.ServerName,
+	}
+
+	if len(upstream.CAFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, caFile := range upstream.CAFiles {
+			data, err := os.ReadFile(caFile)
+			if err != nil {
+				logger.Errorf("error reading upstream CA file %q for upstream %q: %v", caFile, upstream.ID, err)
+				continue
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				logger.Errorf("error parsing upstream CA file %q for upstream %q", caFile, upstream.ID)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if upstream.ClientCertFile != "" && upstream.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(upstream.ClientCertFile, upstream.ClientKeyFile)
+		if err != nil {
+			logger.Errorf("error loading client certificate for upstream %q: %v", upstream.ID, err)
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	return tlsConfig
+}
+
 // setProxyUpstreamHostHeader sets the proxy.Director so that upstream requests
 // receive a host header matching the target URL.
 func setProxyUpstreamHostHeader(proxy *httputil.ReverseProxy, target *url.URL) {
@@ -154,6 +214,20 @@ func setProxyUpstreamHostHeader(proxy *httputil.ReverseProxy, target *url.URL) {
 	}
 }
 
+// setProxyAppendXForwardedHost sets the proxy.Director so that upstream
+// requests carry the original inbound Host in X-Forwarded-Host, unless an
+// outer proxy already set one - in which case its value wins.
+func setProxyAppendXForwardedHost(proxy *httputil.ReverseProxy) {
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		host := req.Host
+		director(req)
+		if req.Header.Get("X-Forwarded-Host") == "" {
+			req.Header.Set("X-Forwarded-Host", host)
+		}
+	}
+}
+
 // setProxyDirector sets the proxy.Director so that request URIs are escaped
 // when proxying to usptream servers.
 func setProxyDirector(proxy *httputil.ReverseProxy) {
@@ -168,13 +242,30 @@ func setProxyDirector(proxy *httputil.ReverseProxy) {
 }
 
 // newWebSocketReverseProxy creates a new reverse proxy for proxying websocket connections.
-func newWebSocketReverseProxy(u *url.URL, skipTLSVerify bool) http.Handler {
-	wsProxy := httputil.NewSingleHostReverseProxy(u)
-	/* #nosec G402 */
-	if skipTLSVerify {
-		wsProxy.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
+func newWebSocketReverseProxy(u *url.URL, upstream options.Upstream) http.Handler {
+	// A unix:// target has no meaningful host to route on, so dial the
+	// socket directly and proxy as if the upstream were on localhost, same
+	// as newReverseProxy.
+	socketPath := ""
+	target := u
+	if target.Scheme == unixScheme {
+		socketPath = target.Path
+		target = &url.URL{Scheme: httpScheme, Host: "localhost"}
+	}
+
+	wsProxy := httputil.NewSingleHostReverseProxy(target)
+	// Share the same Transport (and so the same connection pool and TLS
+	// trust config) as the non-websocket reverse proxy for this upstream.
+	wsProxy.Transport = buildUpstreamTransport(upstream, socketPath)
+
+	preserveHost := upstream.PreserveHost != nil && *upstream.PreserveHost
+	if !preserveHost && upstream.PassHostHeader != nil && !*upstream.PassHostHeader {
+		setProxyUpstreamHostHeader(wsProxy, target)
 	}
+
+	if upstream.AppendXForwardedHost != nil && *upstream.AppendXForwardedHost {
+		setProxyAppendXForwardedHost(wsProxy)
+	}
+
 	return wsProxy
 }
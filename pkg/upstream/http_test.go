@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// newUnixSocketUpstream starts an HTTP server listening on a unix socket
+// under a t.TempDir() and returns the unix:// URL oauth2-proxy's upstream
+// config would point at.
+func newUnixSocketUpstream(t *testing.T, handler http.Handler) *url.URL {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "upstream.sock")
+	listener, err := net.Listen(unixScheme, socketPath)
+	if err != nil {
+		t.Fatalf("net.Listen(%q, %q) error = %v", unixScheme, socketPath, err)
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = os.Remove(socketPath)
+	})
+
+	return &url.URL{Scheme: unixScheme, Path: socketPath}
+}
+
+func TestNewReverseProxyUnixSocket(t *testing.T) {
+	target := newUnixSocketUpstream(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("hello from unix socket"))
+	}))
+
+	proxy := newReverseProxy(target, options.Upstream{ID: "unix-test"}, nil)
+
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rw.Code, http.StatusOK)
+	}
+	if got := rw.Body.String(); got != "hello from unix socket" {
+		t.Errorf("body = %q, want %q", got, "hello from unix socket")
+	}
+}
+
+func TestNewWebSocketReverseProxyUnixSocket(t *testing.T) {
+	target := newUnixSocketUpstream(t, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("hello from unix socket over ws proxy"))
+	}))
+
+	proxy := newWebSocketReverseProxy(target, options.Upstream{ID: "unix-ws-test"})
+
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; newWebSocketReverseProxy should proxy unix-socket upstreams like newReverseProxy does", rw.Code, http.StatusOK)
+	}
+	if got := rw.Body.String(); got != "hello from unix socket over ws proxy" {
+		t.Errorf("body = %q, want %q", got, "hello from unix socket over ws proxy")
+	}
+}
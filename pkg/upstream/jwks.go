@@ -0,0 +1,97 @@
+package upstream
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// JWKSPath is where oauth2-proxy publishes the public keys for its
+// configured JWT upstream request signers, so upstreams can verify the
+// Gap-Signature header without sharing a secret.
+const JWKSPath = "/.well-known/oauth2-proxy/certs.json"
+
+// JWKSKey is the public half of an UpstreamRequestSigner's key material,
+// ready to be published by NewJWKSHandler.
+type JWKSKey struct {
+	KeyID     string
+	Algorithm string // "RS256" or "EdDSA"
+	PublicKey interface{}
+}
+
+// jwk is a minimal JSON Web Key covering the RSA and OKP (Ed25519) key
+// types oauth2-proxy's JWT upstream signers use.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// NewJWKSHandler serves keys as a JWKS document (RFC 7517) at JWKSPath,
+// letting upstreams fetch the verification material for oauth2-proxy's
+// JWT request signers.
+func NewJWKSHandler(keys []JWKSKey) http.Handler {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: make([]jwk, 0, len(keys))}
+
+	for _, k := range keys {
+		key, err := toJWK(k)
+		if err != nil {
+			logger.Errorf("error encoding JWKS key %q: %v", k.KeyID, err)
+			continue
+		}
+		doc.Keys = append(doc.Keys, key)
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		logger.Errorf("error marshalling JWKS document: %v", err)
+		body = []byte(`{"keys":[]}`)
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write(body)
+	})
+}
+
+// toJWK converts a JWKSKey's public key material into its JWK
+// representation.
+func toJWK(k JWKSKey) (jwk, error) {
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: k.KeyID,
+			Alg: k.Algorithm,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return jwk{
+			Kty: "OKP",
+			Use: "sig",
+			Kid: k.KeyID,
+			Alg: k.Algorithm,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	default:
+		return jwk{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
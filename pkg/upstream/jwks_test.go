@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToJWKRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	got, err := toJWK(JWKSKey{KeyID: "rsa-key", Algorithm: "RS256", PublicKey: &key.PublicKey})
+	if err != nil {
+		t.Fatalf("toJWK() error = %v", err)
+	}
+
+	if got.Kty != "RSA" || got.Kid != "rsa-key" || got.Alg != "RS256" {
+		t.Errorf("toJWK() = %+v, want kty=RSA kid=rsa-key alg=RS256", got)
+	}
+	if n, err := base64.RawURLEncoding.DecodeString(got.N); err != nil || len(n) == 0 {
+		t.Errorf("toJWK() N did not decode to a nonempty modulus: %v", err)
+	}
+	if got.E == "" {
+		t.Error("toJWK() E should not be empty for an RSA key")
+	}
+}
+
+func TestToJWKEd25519(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+
+	got, err := toJWK(JWKSKey{KeyID: "ed-key", Algorithm: "EdDSA", PublicKey: pub})
+	if err != nil {
+		t.Fatalf("toJWK() error = %v", err)
+	}
+
+	if got.Kty != "OKP" || got.Crv != "Ed25519" || got.Kid != "ed-key" {
+		t.Errorf("toJWK() = %+v, want kty=OKP crv=Ed25519 kid=ed-key", got)
+	}
+	if x, err := base64.RawURLEncoding.DecodeString(got.X); err != nil || len(x) != ed25519.PublicKeySize {
+		t.Errorf("toJWK() X did not decode to a %d-byte Ed25519 public key: %v", ed25519.PublicKeySize, err)
+	}
+}
+
+func TestToJWKUnsupportedKeyType(t *testing.T) {
+	if _, err := toJWK(JWKSKey{PublicKey: "not a key"}); err == nil {
+		t.Error("toJWK() with an unsupported key type should return an error")
+	}
+}
+
+func TestNewJWKSHandlerServesKeys(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+
+	handler := NewJWKSHandler([]JWKSKey{{KeyID: "ed-key", Algorithm: "EdDSA", PublicKey: pub}})
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v; body = %s", err, rw.Body.String())
+	}
+	if len(doc.Keys) != 1 || doc.Keys[0].Kid != "ed-key" {
+		t.Errorf("JWKS document keys = %+v, want one key with kid=ed-key", doc.Keys)
+	}
+}
+
+func TestNewJWKSHandlerSkipsUnsupportedKeys(t *testing.T) {
+	handler := NewJWKSHandler([]JWKSKey{{KeyID: "bad-key", PublicKey: "not a key"}})
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v; body = %s", err, rw.Body.String())
+	}
+	if len(doc.Keys) != 0 {
+		t.Errorf("JWKS document should omit keys that fail to encode, got %+v", doc.Keys)
+	}
+}
@@ -0,0 +1,51 @@
+package upstream
+
+import (
+	"net/http"
+	"sync"
+)
+
+// jwksRegistry collects the public keys of every jwtRequestSigner built by
+// newJWTRequestSigner, so JWKSHandler can publish them without requiring
+// callers to thread key material back out of the unexported
+// httpUpstreamProxy that holds each signer. It's keyed by KeyID rather than
+// append-only so that reconstructing the upstream chain - e.g. on a SIGHUP
+// config reload - replaces a rotated key's entry instead of publishing both
+// the old and new public keys indefinitely.
+var (
+	jwksRegistryMu sync.Mutex
+	jwksRegistry   = map[string]JWKSKey{}
+)
+
+// registerJWKSKey adds key to the set JWKSHandler serves, replacing any
+// existing entry with the same KeyID.
+func registerJWKSKey(key JWKSKey) {
+	jwksRegistryMu.Lock()
+	defer jwksRegistryMu.Unlock()
+	jwksRegistry[key.KeyID] = key
+}
+
+// ResetJWKSRegistry clears every registered key. Callers that rebuild the
+// upstream chain from scratch - such as a config reload - should call this
+// before reconstructing upstreams, so a key removed from config doesn't
+// stay published forever.
+func ResetJWKSRegistry() {
+	jwksRegistryMu.Lock()
+	defer jwksRegistryMu.Unlock()
+	jwksRegistry = map[string]JWKSKey{}
+}
+
+// JWKSHandler serves the public keys for every configured JWT upstream
+// request signer as a JWKS document (RFC 7517). Callers should mount it at
+// JWKSPath on the proxy's router so upstreams can verify the Gap-Signature
+// header without sharing a secret.
+func JWKSHandler() http.Handler {
+	jwksRegistryMu.Lock()
+	keys := make([]JWKSKey, 0, len(jwksRegistry))
+	for _, key := range jwksRegistry {
+		keys = append(keys, key)
+	}
+	jwksRegistryMu.Unlock()
+
+	return NewJWKSHandler(keys)
+}
@@ -0,0 +1,104 @@
+package upstream
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withCleanJWKSRegistry(t *testing.T) {
+	t.Helper()
+	jwksRegistryMu.Lock()
+	saved := jwksRegistry
+	jwksRegistry = map[string]JWKSKey{}
+	jwksRegistryMu.Unlock()
+	t.Cleanup(func() {
+		jwksRegistryMu.Lock()
+		jwksRegistry = saved
+		jwksRegistryMu.Unlock()
+	})
+}
+
+func TestJWKSHandlerServesRegisteredKeys(t *testing.T) {
+	withCleanJWKSRegistry(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+
+	registerJWKSKey(JWKSKey{KeyID: "key-a", Algorithm: "EdDSA", PublicKey: pub})
+	registerJWKSKey(JWKSKey{KeyID: "key-b", Algorithm: "EdDSA", PublicKey: pub})
+
+	jwksRegistryMu.Lock()
+	got := len(jwksRegistry)
+	jwksRegistryMu.Unlock()
+
+	if got != 2 {
+		t.Fatalf("jwksRegistry has %d keys after two registerJWKSKey calls, want 2", got)
+	}
+
+	// JWKSHandler should snapshot the registry rather than reference it, so
+	// a later registration doesn't retroactively change a handler already
+	// built and mounted.
+	handler := JWKSHandler()
+	registerJWKSKey(JWKSKey{KeyID: "key-c", Algorithm: "EdDSA", PublicKey: pub})
+
+	jwksRegistryMu.Lock()
+	gotAfter := len(jwksRegistry)
+	jwksRegistryMu.Unlock()
+	if gotAfter != 3 {
+		t.Fatalf("jwksRegistry has %d keys after a third registerJWKSKey call, want 3", gotAfter)
+	}
+	if handler == nil {
+		t.Fatal("JWKSHandler() returned nil")
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v; body = %s", err, rw.Body.String())
+	}
+	if len(doc.Keys) != 2 {
+		t.Errorf("JWKSHandler() served %d keys, want the 2 registered before it was built", len(doc.Keys))
+	}
+}
+
+func TestRegisterJWKSKeyReplacesSameKeyID(t *testing.T) {
+	withCleanJWKSRegistry(t)
+
+	registerJWKSKey(JWKSKey{KeyID: "rotating-key", Algorithm: "RS256"})
+	registerJWKSKey(JWKSKey{KeyID: "rotating-key", Algorithm: "EdDSA"})
+
+	jwksRegistryMu.Lock()
+	defer jwksRegistryMu.Unlock()
+
+	if len(jwksRegistry) != 1 {
+		t.Fatalf("jwksRegistry has %d entries after re-registering the same KeyID, want 1 (no duplicates, no stale rotated keys)", len(jwksRegistry))
+	}
+	if got := jwksRegistry["rotating-key"].Algorithm; got != "EdDSA" {
+		t.Errorf("jwksRegistry[\"rotating-key\"].Algorithm = %q, want the latest registration's %q", got, "EdDSA")
+	}
+}
+
+func TestResetJWKSRegistryClearsKeys(t *testing.T) {
+	withCleanJWKSRegistry(t)
+
+	registerJWKSKey(JWKSKey{KeyID: "key-a"})
+	ResetJWKSRegistry()
+
+	jwksRegistryMu.Lock()
+	got := len(jwksRegistry)
+	jwksRegistryMu.Unlock()
+
+	if got != 0 {
+		t.Errorf("jwksRegistry has %d keys after ResetJWKSRegistry(), want 0", got)
+	}
+}
@@ -0,0 +1,118 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHashAndRestoreBodyNilBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Body = nil
+
+	sum, err := hashAndRestoreBody(req)
+	if err != nil {
+		t.Fatalf("hashAndRestoreBody() error = %v", err)
+	}
+	if sum == "" {
+		t.Error("hashAndRestoreBody() with a nil body should still return the empty-input SHA-256, not an empty string")
+	}
+}
+
+func TestHashAndRestoreBodyWithinCap(t *testing.T) {
+	const body = "hello upstream"
+	req := httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	sum, err := hashAndRestoreBody(req)
+	if err != nil {
+		t.Fatalf("hashAndRestoreBody() error = %v", err)
+	}
+	if sum == "" {
+		t.Error("hashAndRestoreBody() should hash a body within maxSignedBodyBytes")
+	}
+
+	restored, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading restored body: %v", err)
+	}
+	if string(restored) != body {
+		t.Errorf("restored body = %q, want %q", restored, body)
+	}
+}
+
+func TestHashAndRestoreBodyOverCapIsSkipped(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("oversized"))
+	req.ContentLength = maxSignedBodyBytes + 1
+
+	sum, err := hashAndRestoreBody(req)
+	if err != nil {
+		t.Fatalf("hashAndRestoreBody() error = %v", err)
+	}
+	if sum != "" {
+		t.Error("hashAndRestoreBody() should skip hashing (and buffering) a body over maxSignedBodyBytes")
+	}
+}
+
+func TestHashHeadersChangesWithHeaderValue(t *testing.T) {
+	headers := []string{"Authorization", "Gap-Auth"}
+
+	req1 := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req1.Header.Set("Authorization", "Bearer one")
+	req1.Header.Set("Gap-Auth", "user@example.com")
+
+	req2 := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req2.Header.Set("Authorization", "Bearer one")
+	req2.Header.Set("Gap-Auth", "attacker@example.com")
+
+	if hashHeaders(req1, headers) == hashHeaders(req2, headers) {
+		t.Error("hashHeaders() should differ when a signed header's value changes")
+	}
+}
+
+func TestHashHeadersStableForSameValues(t *testing.T) {
+	headers := []string{"Authorization", "Gap-Auth"}
+
+	req1 := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req1.Header.Set("Authorization", "Bearer one")
+	req1.Header.Set("Gap-Auth", "user@example.com")
+
+	req2 := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req2.Header.Set("Authorization", "Bearer one")
+	req2.Header.Set("Gap-Auth", "user@example.com")
+
+	if hashHeaders(req1, headers) != hashHeaders(req2, headers) {
+		t.Error("hashHeaders() should be stable for identical header values")
+	}
+}
+
+func TestHashHeadersMissingHeaderMatchesEmpty(t *testing.T) {
+	headers := []string{"X-Forwarded-Email"}
+
+	withHeader := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	withHeader.Header.Set("X-Forwarded-Email", "")
+
+	withoutHeader := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	// Both hash to the same value today (an unset header and a header set
+	// to "" are indistinguishable via Header.Get), but this pins the
+	// current behavior so a future change is deliberate rather than silent.
+	if hashHeaders(withHeader, headers) != hashHeaders(withoutHeader, headers) {
+		t.Error("hashHeaders() unexpectedly distinguished an empty header from a missing one")
+	}
+}
+
+func TestHashAndRestoreBodyUnknownLengthIsSkipped(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("streamed"))
+	req.ContentLength = -1
+
+	sum, err := hashAndRestoreBody(req)
+	if err != nil {
+		t.Fatalf("hashAndRestoreBody() error = %v", err)
+	}
+	if sum != "" {
+		t.Error("hashAndRestoreBody() should skip hashing a body of unknown length rather than buffering it unbounded")
+	}
+}
@@ -0,0 +1,146 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	proxyProtocolV1 = "v1"
+	proxyProtocolV2 = "v2"
+)
+
+// proxyProtocolV2Signature is the fixed 12-byte signature that opens every
+// PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// proxyProtocolContextKeyType is an unexported type for the context key
+// below, per the standard library's guidance to avoid collisions between
+// packages using context.WithValue.
+type proxyProtocolContextKeyType struct{}
+
+// proxyProtocolContextKey is the context key under which the inbound
+// request's client address is stashed so that a Transport's DialContext -
+// which otherwise has no access to the request being proxied - can emit a
+// PROXY protocol header carrying it.
+var proxyProtocolContextKey = proxyProtocolContextKeyType{}
+
+// withProxyProtocolSourceAddr returns req with its RemoteAddr attached to
+// its context for a later proxyProtocolDialContext to consume.
+func withProxyProtocolSourceAddr(req *http.Request) *http.Request {
+	ctx := context.WithValue(req.Context(), proxyProtocolContextKey, req.RemoteAddr)
+	return req.WithContext(ctx)
+}
+
+// proxyProtocolDialContext wraps dial so that, once the TCP connection to
+// the upstream is established, it writes a PROXY protocol header (v1 or v2,
+// per version) carrying the client address stashed in ctx by
+// withProxyProtocolSourceAddr before handing the connection back to the
+// caller. This lets the backend see the real client address even though
+// oauth2-proxy is terminating the inbound connection itself.
+func proxyProtocolDialContext(dial dialContextFunc, version string) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		sourceAddr, _ := ctx.Value(proxyProtocolContextKey).(string)
+		header, err := buildProxyProtocolHeader(version, conn, sourceAddr)
+		if err != nil {
+			logger.Errorf("error building PROXY protocol header, closing upstream connection: %v", err)
+			conn.Close()
+			return nil, err
+		}
+
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// dialContextFunc matches the signature net.Dialer.DialContext and
+// http.Transport.DialContext share, so dialers can be wrapped generically.
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// buildProxyProtocolHeader builds the PROXY protocol header for a
+// connection from sourceAddr (the original client's host:port) to conn's
+// remote address. If either address can't be parsed as a TCP host:port -
+// e.g. sourceAddr is empty because the request didn't carry one - it falls
+// back to a v1 "UNKNOWN" header so the backend ignores the connection
+// metadata rather than misreading a zero address as a real client.
+func buildProxyProtocolHeader(version string, conn net.Conn, sourceAddr string) ([]byte, error) {
+	srcIP, srcPort, srcOK := splitHostPortIP(sourceAddr)
+	dstIP, dstPort, dstOK := splitHostPortIP(conn.RemoteAddr().String())
+
+	if !srcOK || !dstOK || (srcIP.To4() == nil) != (dstIP.To4() == nil) {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+
+	if version == proxyProtocolV2 {
+		return buildProxyProtocolV2Header(srcIP, srcPort, dstIP, dstPort), nil
+	}
+	return buildProxyProtocolV1Header(srcIP, srcPort, dstIP, dstPort), nil
+}
+
+// splitHostPortIP splits addr into an IP and port, reporting false if addr
+// isn't a valid host:port pair with a parseable IP host.
+func splitHostPortIP(addr string) (net.IP, int, bool) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portStr)
+	if err != nil || ip == nil {
+		return nil, 0, false
+	}
+	return ip, port, true
+}
+
+// buildProxyProtocolV1Header builds a PROXY protocol v1 (text) header.
+func buildProxyProtocolV1Header(srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) []byte {
+	family := "TCP4"
+	if srcIP.To4() == nil {
+		family = "TCP6"
+	}
+	return []byte("PROXY " + family + " " + srcIP.String() + " " + dstIP.String() + " " +
+		strconv.Itoa(srcPort) + " " + strconv.Itoa(dstPort) + "\r\n")
+}
+
+// buildProxyProtocolV2Header builds a PROXY protocol v2 (binary) header:
+// the 12-byte signature, a version/command byte (0x21: version 2, PROXY
+// command), an address-family/protocol byte (TCP over IPv4 or IPv6), a 2-byte big-endian address length, and
+// finally the source IP, destination IP, source port and destination port.
+func buildProxyProtocolV2Header(srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) []byte {
+	const versionCommand = 0x21
+
+	var famProto byte
+	var srcBytes, dstBytes []byte
+	if v4 := srcIP.To4(); v4 != nil {
+		famProto = 0x11 // AF_INET (1) << 4 | STREAM (1)
+		srcBytes, dstBytes = v4, dstIP.To4()
+	} else {
+		famProto = 0x21 // AF_INET6 (2) << 4 | STREAM (1)
+		srcBytes, dstBytes = srcIP.To16(), dstIP.To16()
+	}
+
+	addrLen := len(srcBytes) + len(dstBytes) + 4 // + 2 bytes each for src/dst ports
+
+	header := make([]byte, 0, len(proxyProtocolV2Signature)+4+addrLen)
+	header = append(header, proxyProtocolV2Signature...)
+	header = append(header, versionCommand, famProto)
+	header = binary.BigEndian.AppendUint16(header, uint16(addrLen))
+	header = append(header, srcBytes...)
+	header = append(header, dstBytes...)
+	header = binary.BigEndian.AppendUint16(header, uint16(srcPort))
+	header = binary.BigEndian.AppendUint16(header, uint16(dstPort))
+	return header
+}
@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestBuildProxyProtocolV1Header(t *testing.T) {
+	tests := []struct {
+		name    string
+		srcIP   string
+		srcPort int
+		dstIP   string
+		dstPort int
+		want    string
+	}{
+		{"ipv4", "203.0.113.5", 51234, "198.51.100.9", 443, "PROXY TCP4 203.0.113.5 198.51.100.9 51234 443\r\n"},
+		{"ipv6", "2001:db8::1", 51234, "2001:db8::2", 443, "PROXY TCP6 2001:db8::1 2001:db8::2 51234 443\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildProxyProtocolV1Header(net.ParseIP(tt.srcIP), tt.srcPort, net.ParseIP(tt.dstIP), tt.dstPort)
+			if string(got) != tt.want {
+				t.Errorf("buildProxyProtocolV1Header() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildProxyProtocolV2Header(t *testing.T) {
+	srcIP := net.ParseIP("203.0.113.5")
+	dstIP := net.ParseIP("198.51.100.9")
+	header := buildProxyProtocolV2Header(srcIP, 51234, dstIP, 443)
+
+	if !bytes.HasPrefix(header, proxyProtocolV2Signature) {
+		t.Fatalf("header does not start with the PROXY v2 signature: %x", header)
+	}
+
+	rest := header[len(proxyProtocolV2Signature):]
+	if rest[0] != 0x21 {
+		t.Errorf("version/command byte = %#x, want 0x21", rest[0])
+	}
+	if rest[1] != 0x11 {
+		t.Errorf("family/proto byte = %#x, want 0x11 (AF_INET/STREAM)", rest[1])
+	}
+
+	addrLen := binary.BigEndian.Uint16(rest[2:4])
+	if addrLen != 12 {
+		t.Fatalf("address length = %d, want 12 for two IPv4 addresses + two ports", addrLen)
+	}
+
+	addresses := rest[4 : 4+addrLen]
+	if !bytes.Equal(addresses[0:4], srcIP.To4()) {
+		t.Errorf("source address = %v, want %v", addresses[0:4], srcIP.To4())
+	}
+	if !bytes.Equal(addresses[4:8], dstIP.To4()) {
+		t.Errorf("destination address = %v, want %v", addresses[4:8], dstIP.To4())
+	}
+	if got := binary.BigEndian.Uint16(addresses[8:10]); got != 51234 {
+		t.Errorf("source port = %d, want 51234", got)
+	}
+	if got := binary.BigEndian.Uint16(addresses[10:12]); got != 443 {
+		t.Errorf("destination port = %d, want 443", got)
+	}
+}
+
+func TestSplitHostPortIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		wantOK  bool
+		wantIP  string
+		wantPrt int
+	}{
+		{"valid ipv4", "203.0.113.5:51234", true, "203.0.113.5", 51234},
+		{"valid ipv6", "[2001:db8::1]:51234", true, "2001:db8::1", 51234},
+		{"no port", "203.0.113.5", false, "", 0},
+		{"empty", "", false, "", 0},
+		{"non-ip host", "example.com:80", false, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port, ok := splitHostPortIP(tt.addr)
+			if ok != tt.wantOK {
+				t.Fatalf("splitHostPortIP(%q) ok = %v, want %v", tt.addr, ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if !ip.Equal(net.ParseIP(tt.wantIP)) {
+				t.Errorf("splitHostPortIP(%q) ip = %v, want %v", tt.addr, ip, tt.wantIP)
+			}
+			if port != tt.wantPrt {
+				t.Errorf("splitHostPortIP(%q) port = %d, want %d", tt.addr, port, tt.wantPrt)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package upstream
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRewritePath(t *testing.T) {
+	rules := []rewriteRule{
+		{
+			fromRegex:  regexp.MustCompile(`^/api/v1/users/(?P<id>\d+)$`),
+			toTemplate: "/internal/users/{id}",
+		},
+		{
+			fromRegex:  regexp.MustCompile(`^/api/v1/orders/(?P<id>\w+)/items/(?P<item>\w+)$`),
+			toTemplate: "/internal/orders/{id}/items/{item}",
+		},
+		{
+			// Overlaps the first rule for numeric user IDs.
+			fromRegex:  regexp.MustCompile(`^/api/v1/users/(?P<id>\w+)$`),
+			toTemplate: "/fallback/users/{id}",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		wantPath    string
+		wantMatched bool
+	}{
+		{"single capture", "/api/v1/users/42", "/internal/users/42", true},
+		{"multiple captures", "/api/v1/orders/abc/items/xyz", "/internal/orders/abc/items/xyz", true},
+		{"no rule matches", "/unrelated/path", "/unrelated/path", false},
+		{"first rule wins on overlap", "/api/v1/users/007", "/internal/users/007", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotMatched := rewritePath(rules, tt.path)
+			if gotMatched != tt.wantMatched {
+				t.Fatalf("rewritePath(%q) matched = %v, want %v", tt.path, gotMatched, tt.wantMatched)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("rewritePath(%q) = %q, want %q", tt.path, gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"single placeholder", "/internal/users/{id}", "/internal/users/${id}"},
+		{"multiple placeholders", "/internal/orders/{id}/items/{item}", "/internal/orders/${id}/items/${item}"},
+		{"no placeholders", "/internal/static", "/internal/static"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandTemplate(tt.template); got != tt.want {
+				t.Errorf("expandTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mbland/hmacauth"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+// UpstreamRequestSigner signs a proxied request in place, attaching
+// whatever header(s) its scheme uses so the upstream can verify the
+// request actually traversed oauth2-proxy.
+type UpstreamRequestSigner interface {
+	SignRequest(req *http.Request) error
+}
+
+// newUpstreamRequestSigner builds the UpstreamRequestSigner configured by
+// sigData. A nil sigData (no signing configured) returns a nil signer and
+// no error.
+func newUpstreamRequestSigner(sigData *options.SignatureData) (UpstreamRequestSigner, error) {
+	if sigData == nil {
+		return nil, nil
+	}
+
+	switch sigData.Scheme {
+	case "", options.SignatureSchemeHMAC:
+		return &hmacRequestSigner{
+			auth: hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key), SignatureHeader, SignatureHeaders),
+		}, nil
+	case options.SignatureSchemeJWTRS256, options.SignatureSchemeJWTEd25519:
+		return newJWTRequestSigner(sigData)
+	default:
+		return nil, fmt.Errorf("unknown upstream request signing scheme %q", sigData.Scheme)
+	}
+}
+
+// hmacRequestSigner is the original oauth2-proxy signing scheme: a shared
+// secret HMAC over a fixed set of headers, attached in SignatureHeader.
+type hmacRequestSigner struct {
+	auth hmacauth.HmacAuth
+}
+
+func (s *hmacRequestSigner) SignRequest(req *http.Request) error {
+	s.auth.SignRequest(req)
+	return nil
+}
@@ -0,0 +1,164 @@
+package upstream
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+const (
+	defaultDialTimeout           = 0 * time.Second
+	defaultKeepAlive             = 0 * time.Second
+	defaultMaxIdleConns          = 100
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinueTimeout = 1 * time.Second
+)
+
+// transportCacheKey identifies a fully-built upstream Transport
+// configuration. Upstreams that resolve to the same key share a Transport,
+// and so its connection pool, instead of each paying for their own dialer
+// and TLS handshakes against what may be the same backend.
+type transportCacheKey struct {
+	dialTimeout           time.Duration
+	keepAlive             time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	maxConnsPerHost       int
+	idleConnTimeout       time.Duration
+	tlsHandshakeTimeout   time.Duration
+	expectContinueTimeout time.Duration
+	responseHeaderTimeout time.Duration
+	disableKeepAlives     bool
+	forceAttemptHTTP2     bool
+	disableCompression    bool
+
+	caFiles               string
+	clientCertFile        string
+	clientKeyFile         string
+	serverName            string
+	insecureSkipTLSVerify bool
+	proxyProtocol         string
+	unixSocketPath        string
+}
+
+var (
+	transportCacheMu sync.Mutex
+	transportCache   = map[transportCacheKey]*http.Transport{}
+)
+
+// buildUpstreamTransport returns the *http.Transport for upstream, building
+// and caching a new one on first use. The same Transport is handed back on
+// every subsequent call with an equivalent configuration, so upstreams with
+// identical tunables (and, commonly, the same backend) share one
+// connection pool across both the HTTP and WebSocket reverse proxies.
+//
+// socketPath is the unix socket to dial instead of upstream's host:port TCP
+// address; pass "" for ordinary TCP upstreams. Dialing differently doesn't
+// otherwise change how the Transport is tuned, so unix-socket upstreams
+// still get DialTimeout/MaxIdleConns/IdleConnTimeout/etc like any other.
+func buildUpstreamTransport(upstream options.Upstream, socketPath string) *http.Transport {
+	forceAttemptHTTP2 := boolOrDefault(upstream.ForceAttemptHTTP2, true)
+	disableKeepAlives := boolOrDefault(upstream.DisableKeepAlives, false)
+	if upstream.ProxyProtocol != "" {
+		// A PROXY header describes exactly one client connection. Letting
+		// the resulting conn be reused - via keep-alive or HTTP/2
+		// multiplexing - for a different inbound request would silently
+		// attribute that request's traffic to the wrong client. Force
+		// one-shot connections instead.
+		forceAttemptHTTP2 = false
+		disableKeepAlives = true
+	}
+
+	key := transportCacheKey{
+		dialTimeout:           durationOrDefault(upstream.DialTimeout, defaultDialTimeout),
+		keepAlive:             durationOrDefault(upstream.KeepAlive, defaultKeepAlive),
+		maxIdleConns:          intOrDefault(upstream.MaxIdleConns, defaultMaxIdleConns),
+		maxIdleConnsPerHost:   intOrDefault(upstream.MaxIdleConnsPerHost, 0),
+		maxConnsPerHost:       intOrDefault(upstream.MaxConnsPerHost, 0),
+		idleConnTimeout:       durationOrDefault(upstream.IdleConnTimeout, defaultIdleConnTimeout),
+		tlsHandshakeTimeout:   durationOrDefault(upstream.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		expectContinueTimeout: durationOrDefault(upstream.ExpectContinueTimeout, defaultExpectContinueTimeout),
+		responseHeaderTimeout: durationOrDefault(upstream.ResponseHeaderTimeout, 0),
+		disableKeepAlives:     disableKeepAlives,
+		forceAttemptHTTP2:     forceAttemptHTTP2,
+		disableCompression:    boolOrDefault(upstream.DisableCompression, false),
+
+		caFiles:               strings.Join(upstream.CAFiles, ","),
+		clientCertFile:        upstream.ClientCertFile,
+		clientKeyFile:         upstream.ClientKeyFile,
+		serverName:            upstream.ServerName,
+		insecureSkipTLSVerify: upstream.InsecureSkipTLSVerify,
+		proxyProtocol:         upstream.ProxyProtocol,
+		unixSocketPath:        socketPath,
+	}
+
+	transportCacheMu.Lock()
+	defer transportCacheMu.Unlock()
+
+	if transport, ok := transportCache[key]; ok {
+		return transport
+	}
+
+	var dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	if socketPath != "" {
+		dialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{
+				Timeout:   key.dialTimeout,
+				KeepAlive: key.keepAlive,
+			}).DialContext(ctx, unixScheme, socketPath)
+		}
+	} else {
+		dialContext = (&net.Dialer{
+			Timeout:   key.dialTimeout,
+			KeepAlive: key.keepAlive,
+		}).DialContext
+	}
+	if key.proxyProtocol != "" {
+		dialContext = proxyProtocolDialContext(dialContext, key.proxyProtocol)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialContext,
+		TLSClientConfig:       buildUpstreamTLSConfig(upstream),
+		ForceAttemptHTTP2:     key.forceAttemptHTTP2,
+		MaxIdleConns:          key.maxIdleConns,
+		MaxIdleConnsPerHost:   key.maxIdleConnsPerHost,
+		MaxConnsPerHost:       key.maxConnsPerHost,
+		IdleConnTimeout:       key.idleConnTimeout,
+		TLSHandshakeTimeout:   key.tlsHandshakeTimeout,
+		ExpectContinueTimeout: key.expectContinueTimeout,
+		ResponseHeaderTimeout: key.responseHeaderTimeout,
+		DisableKeepAlives:     key.disableKeepAlives,
+		DisableCompression:    key.disableCompression,
+	}
+	transportCache[key] = transport
+	return transport
+}
+
+func durationOrDefault(d *options.Duration, def time.Duration) time.Duration {
+	if d == nil {
+		return def
+	}
+	return d.Duration()
+}
+
+func intOrDefault(i *int, def int) int {
+	if i == nil {
+		return def
+	}
+	return *i
+}
+
+func boolOrDefault(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
@@ -0,0 +1,69 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+)
+
+func TestBuildUpstreamTransportCaching(t *testing.T) {
+	upstream := options.Upstream{ID: "test"}
+
+	t1 := buildUpstreamTransport(upstream, "")
+	t2 := buildUpstreamTransport(upstream, "")
+	if t1 != t2 {
+		t.Error("buildUpstreamTransport() with identical configs should return the same cached *http.Transport")
+	}
+
+	t3 := buildUpstreamTransport(upstream, "/tmp/different.sock")
+	if t1 == t3 {
+		t.Error("buildUpstreamTransport() with a different socketPath should not share a cached *http.Transport")
+	}
+}
+
+func TestBuildUpstreamTransportProxyProtocolForcesOneShotConns(t *testing.T) {
+	upstream := options.Upstream{ID: "test-proxy-protocol", ProxyProtocol: proxyProtocolV1}
+
+	transport := buildUpstreamTransport(upstream, "")
+	if !transport.DisableKeepAlives {
+		t.Error("buildUpstreamTransport() with ProxyProtocol set must force DisableKeepAlives so a PROXY header is never replayed onto a different client's request")
+	}
+	if transport.ForceAttemptHTTP2 {
+		t.Error("buildUpstreamTransport() with ProxyProtocol set must not attempt HTTP/2, whose multiplexing would share one PROXY-tagged connection across requests")
+	}
+}
+
+func TestDurationOrDefault(t *testing.T) {
+	def := 5 * time.Second
+	if got := durationOrDefault(nil, def); got != def {
+		t.Errorf("durationOrDefault(nil, %v) = %v, want %v", def, got, def)
+	}
+
+	want := options.Duration(10 * time.Second)
+	if got := durationOrDefault(&want, def); got != 10*time.Second {
+		t.Errorf("durationOrDefault(&want, %v) = %v, want %v", def, got, 10*time.Second)
+	}
+}
+
+func TestIntOrDefault(t *testing.T) {
+	if got := intOrDefault(nil, 7); got != 7 {
+		t.Errorf("intOrDefault(nil, 7) = %d, want 7", got)
+	}
+
+	val := 42
+	if got := intOrDefault(&val, 7); got != 42 {
+		t.Errorf("intOrDefault(&val, 7) = %d, want 42", got)
+	}
+}
+
+func TestBoolOrDefault(t *testing.T) {
+	if got := boolOrDefault(nil, true); got != true {
+		t.Errorf("boolOrDefault(nil, true) = %v, want true", got)
+	}
+
+	val := false
+	if got := boolOrDefault(&val, true); got != false {
+		t.Errorf("boolOrDefault(&val, true) = %v, want false", got)
+	}
+}